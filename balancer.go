@@ -0,0 +1,210 @@
+// Licensed to ClickHouse, Inc. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. ClickHouse, Inc. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var errNoAddresses = fmt.Errorf("clickhouse: no addresses to dial")
+
+// BalancerState carries information about prior picks that a Balancer can use
+// when choosing the next address, without exposing control over the
+// underlying connection pool.
+type BalancerState struct {
+	// LastIndex is the index into addrs of the last address picked, or -1 if
+	// no address has been picked yet.
+	LastIndex int
+}
+
+// Balancer selects which address a new connection should be dialed against,
+// and is informed of the outcome of dials and queries so it can steer
+// traffic away from unhealthy nodes. A Balancer is set via Options.Balancer
+// and is mutually exclusive with Options.ConnOpenStrategy.
+type Balancer interface {
+	// Pick selects an address from addrs to dial.
+	Pick(ctx context.Context, addrs []string, state BalancerState) (string, error)
+	// Report is called with the outcome of a dial or query against addr. err
+	// is nil on success.
+	Report(addr string, err error)
+}
+
+type inOrderBalancer struct{}
+
+// NewInOrderBalancer returns a Balancer that always picks the next address
+// following the last one picked, wrapping around to the start of addrs.
+func NewInOrderBalancer() Balancer {
+	return inOrderBalancer{}
+}
+
+func (inOrderBalancer) Pick(_ context.Context, addrs []string, state BalancerState) (string, error) {
+	if len(addrs) == 0 {
+		return "", errNoAddresses
+	}
+	return addrs[(state.LastIndex+1)%len(addrs)], nil
+}
+
+func (inOrderBalancer) Report(string, error) {}
+
+type roundRobinBalancer struct {
+	mu   sync.Mutex
+	next int
+}
+
+// NewRoundRobinBalancer returns a Balancer that cycles through addrs in turn,
+// independent of which address was picked last.
+func NewRoundRobinBalancer() Balancer {
+	return &roundRobinBalancer{}
+}
+
+func (b *roundRobinBalancer) Pick(_ context.Context, addrs []string, _ BalancerState) (string, error) {
+	if len(addrs) == 0 {
+		return "", errNoAddresses
+	}
+	b.mu.Lock()
+	addr := addrs[b.next%len(addrs)]
+	b.next++
+	b.mu.Unlock()
+	return addr, nil
+}
+
+func (*roundRobinBalancer) Report(string, error) {}
+
+type randomBalancer struct {
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// NewRandomBalancer returns a Balancer that picks a uniformly random address
+// from addrs on every call to Pick.
+func NewRandomBalancer() Balancer {
+	return &randomBalancer{rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (b *randomBalancer) Pick(_ context.Context, addrs []string, _ BalancerState) (string, error) {
+	if len(addrs) == 0 {
+		return "", errNoAddresses
+	}
+	b.mu.Lock()
+	addr := addrs[b.rand.Intn(len(addrs))]
+	b.mu.Unlock()
+	return addr, nil
+}
+
+func (*randomBalancer) Report(string, error) {}
+
+// HealthAwareBalancerConfig configures NewHealthAwareBalancer.
+type HealthAwareBalancerConfig struct {
+	// FailureThreshold is the number of consecutive failures against an
+	// address required to eject it from the rotation. default 3.
+	FailureThreshold int
+	// EjectionDuration is the base duration an address is ejected for after
+	// FailureThreshold is reached; it doubles on each further consecutive
+	// failure, up to MaxEjectionDuration. default 30s.
+	EjectionDuration time.Duration
+	// MaxEjectionDuration caps the exponential backoff applied to
+	// EjectionDuration. default 5m.
+	MaxEjectionDuration time.Duration
+}
+
+type addrHealth struct {
+	consecutiveFailures int
+	ejectedUntil        time.Time
+}
+
+type healthAwareBalancer struct {
+	config     HealthAwareBalancerConfig
+	underlying Balancer
+
+	mu    sync.Mutex
+	state map[string]*addrHealth
+}
+
+// NewHealthAwareBalancer returns a Balancer that round-robins across addrs
+// while temporarily ejecting any address that has failed FailureThreshold
+// times in a row, backing off exponentially and probing ejected addresses
+// again (half-open) once their ejection window elapses.
+func NewHealthAwareBalancer(config HealthAwareBalancerConfig) Balancer {
+	if config.FailureThreshold <= 0 {
+		config.FailureThreshold = 3
+	}
+	if config.EjectionDuration <= 0 {
+		config.EjectionDuration = 30 * time.Second
+	}
+	if config.MaxEjectionDuration <= 0 {
+		config.MaxEjectionDuration = 5 * time.Minute
+	}
+	return &healthAwareBalancer{
+		config:     config,
+		underlying: NewRoundRobinBalancer(),
+		state:      make(map[string]*addrHealth),
+	}
+}
+
+func (b *healthAwareBalancer) Pick(ctx context.Context, addrs []string, state BalancerState) (string, error) {
+	if len(addrs) == 0 {
+		return "", errNoAddresses
+	}
+	now := time.Now()
+	b.mu.Lock()
+	healthy := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		if h, ok := b.state[addr]; !ok || !now.Before(h.ejectedUntil) {
+			healthy = append(healthy, addr)
+		}
+	}
+	b.mu.Unlock()
+	if len(healthy) == 0 {
+		// every address is ejected: probe the whole set (half-open).
+		healthy = addrs
+	}
+	return b.underlying.Pick(ctx, healthy, state)
+}
+
+func (b *healthAwareBalancer) Report(addr string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	h, ok := b.state[addr]
+	if !ok {
+		h = &addrHealth{}
+		b.state[addr] = h
+	}
+	if err == nil {
+		h.consecutiveFailures = 0
+		h.ejectedUntil = time.Time{}
+		return
+	}
+	h.consecutiveFailures++
+	if h.consecutiveFailures < b.config.FailureThreshold {
+		return
+	}
+	// Only shift EjectionDuration left when the result is known to fit within
+	// MaxEjectionDuration (checked by shifting the cap right instead), so the
+	// shift can never overflow or flip the sign of time.Duration (an int64);
+	// otherwise the backoff is clamped to MaxEjectionDuration.
+	exponent := h.consecutiveFailures - b.config.FailureThreshold
+	backoff := b.config.MaxEjectionDuration
+	if b.config.EjectionDuration <= b.config.MaxEjectionDuration>>uint(exponent) {
+		backoff = b.config.EjectionDuration << uint(exponent)
+	}
+	h.ejectedUntil = time.Now().Add(backoff)
+}
@@ -0,0 +1,239 @@
+// Licensed to ClickHouse, Inc. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. ClickHouse, Inc. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package clickhouse
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInOrderBalancerPick(t *testing.T) {
+	b := NewInOrderBalancer()
+	addrs := []string{"a", "b", "c"}
+	if got, err := b.Pick(context.Background(), addrs, BalancerState{LastIndex: -1}); err != nil || got != "a" {
+		t.Fatalf("Pick(LastIndex=-1) = %q, %v, want %q, nil", got, err, "a")
+	}
+	if got, err := b.Pick(context.Background(), addrs, BalancerState{LastIndex: 0}); err != nil || got != "b" {
+		t.Fatalf("Pick(LastIndex=0) = %q, %v, want %q, nil", got, err, "b")
+	}
+	if got, err := b.Pick(context.Background(), addrs, BalancerState{LastIndex: 2}); err != nil || got != "a" {
+		t.Fatalf("Pick(LastIndex=2) = %q, %v, want wraparound to %q, nil", got, err, "a")
+	}
+	if _, err := b.Pick(context.Background(), nil, BalancerState{LastIndex: -1}); err == nil {
+		t.Fatalf("Pick with no addresses: expected error, got nil")
+	}
+}
+
+func TestRoundRobinBalancerPick(t *testing.T) {
+	b := NewRoundRobinBalancer()
+	addrs := []string{"a", "b", "c"}
+	var got []string
+	for i := 0; i < 4; i++ {
+		addr, err := b.Pick(context.Background(), addrs, BalancerState{LastIndex: -1})
+		if err != nil {
+			t.Fatalf("Pick: %v", err)
+		}
+		got = append(got, addr)
+	}
+	want := []string{"a", "b", "c", "a"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Pick sequence = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestHealthAwareBalancerEjectsAfterThreshold(t *testing.T) {
+	b := NewHealthAwareBalancer(HealthAwareBalancerConfig{
+		FailureThreshold:    2,
+		EjectionDuration:    time.Hour,
+		MaxEjectionDuration: time.Hour,
+	})
+	addrs := []string{"a", "b"}
+
+	b.Report("a", errNoAddresses)
+	b.Report("a", errNoAddresses)
+
+	for i := 0; i < 10; i++ {
+		addr, err := b.Pick(context.Background(), addrs, BalancerState{LastIndex: -1})
+		if err != nil {
+			t.Fatalf("Pick: %v", err)
+		}
+		if addr == "a" {
+			t.Fatalf("Pick returned ejected address %q", addr)
+		}
+	}
+
+	// a successful report clears the ejection.
+	b.Report("a", nil)
+	sawA := false
+	for i := 0; i < 20; i++ {
+		addr, err := b.Pick(context.Background(), addrs, BalancerState{LastIndex: -1})
+		if err != nil {
+			t.Fatalf("Pick: %v", err)
+		}
+		if addr == "a" {
+			sawA = true
+			break
+		}
+	}
+	if !sawA {
+		t.Fatalf("expected address %q to be eligible again after a successful Report", "a")
+	}
+}
+
+func TestHealthAwareBalancerEjectsAllIsHalfOpen(t *testing.T) {
+	b := NewHealthAwareBalancer(HealthAwareBalancerConfig{
+		FailureThreshold:    1,
+		EjectionDuration:    time.Hour,
+		MaxEjectionDuration: time.Hour,
+	})
+	addrs := []string{"a", "b"}
+	b.Report("a", errNoAddresses)
+	b.Report("b", errNoAddresses)
+
+	// every address is ejected: Pick must still return something from addrs
+	// (half-open probing) rather than erroring out.
+	addr, err := b.Pick(context.Background(), addrs, BalancerState{LastIndex: -1})
+	if err != nil {
+		t.Fatalf("Pick: %v", err)
+	}
+	if addr != "a" && addr != "b" {
+		t.Fatalf("Pick = %q, want one of %v", addr, addrs)
+	}
+}
+
+// TestHealthAwareBalancerBackoffExponentIsCapped guards against the backoff
+// shift overflowing/flipping sign when consecutiveFailures grows large with a
+// small EjectionDuration and a large MaxEjectionDuration - the clamp on the
+// shift result alone isn't enough if the shift itself has already overflowed.
+func TestHealthAwareBalancerBackoffExponentIsCapped(t *testing.T) {
+	b := NewHealthAwareBalancer(HealthAwareBalancerConfig{
+		FailureThreshold:    1,
+		EjectionDuration:    time.Nanosecond,
+		MaxEjectionDuration: 24 * time.Hour,
+	}).(*healthAwareBalancer)
+
+	for i := 0; i < 100; i++ {
+		b.Report("a", errNoAddresses)
+	}
+
+	b.mu.Lock()
+	ejectedUntil := b.state["a"].ejectedUntil
+	b.mu.Unlock()
+
+	if !ejectedUntil.After(time.Now()) {
+		t.Fatalf("address should still be ejected, ejectedUntil = %v", ejectedUntil)
+	}
+	if ejectedUntil.After(time.Now().Add(24*time.Hour + time.Minute)) {
+		t.Fatalf("ejectedUntil = %v is far beyond MaxEjectionDuration, the shift likely overflowed", ejectedUntil)
+	}
+}
+
+// recordingBalancer lets tests observe the Pick/Report calls Options.open
+// makes, without depending on a particular built-in's internal state.
+type recordingBalancer struct {
+	mu      sync.Mutex
+	picks   []BalancerState
+	reports []string
+}
+
+func (b *recordingBalancer) Pick(_ context.Context, addrs []string, state BalancerState) (string, error) {
+	b.mu.Lock()
+	b.picks = append(b.picks, state)
+	b.mu.Unlock()
+	if len(addrs) == 0 {
+		return "", errNoAddresses
+	}
+	return addrs[0], nil
+}
+
+func (b *recordingBalancer) Report(addr string, _ error) {
+	b.mu.Lock()
+	b.reports = append(b.reports, addr)
+	b.mu.Unlock()
+}
+
+func TestOptionsOpenDrivesBalancer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	balancer := &recordingBalancer{}
+	o := &Options{Addr: []string{ln.Addr().String()}, Balancer: balancer}
+
+	conn, addr, err := o.open(context.Background())
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	conn.Close()
+	if addr != ln.Addr().String() {
+		t.Fatalf("addr = %q, want %q", addr, ln.Addr().String())
+	}
+	if len(balancer.picks) != 1 || balancer.picks[0].LastIndex != -1 {
+		t.Fatalf("expected one Pick with LastIndex=-1, got %+v", balancer.picks)
+	}
+	if len(balancer.reports) != 1 || balancer.reports[0] != addr {
+		t.Fatalf("expected Report(%q, ...), got %+v", addr, balancer.reports)
+	}
+
+	conn2, _, err := o.open(context.Background())
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	conn2.Close()
+	if len(balancer.picks) != 2 || balancer.picks[1].LastIndex != 0 {
+		t.Fatalf("expected second Pick to see LastIndex=0, got %+v", balancer.picks)
+	}
+}
+
+func TestOptionsReportQueryError(t *testing.T) {
+	balancer := &recordingBalancer{}
+	o := &Options{Balancer: balancer}
+
+	o.ReportQueryError("some-addr", errNoAddresses)
+	if len(balancer.reports) != 1 || balancer.reports[0] != "some-addr" {
+		t.Fatalf("expected Report(%q, ...), got %+v", "some-addr", balancer.reports)
+	}
+}
+
+func TestOptionsValidateRejectsConflictingBalancer(t *testing.T) {
+	o := &Options{Balancer: NewRandomBalancer(), ConnOpenStrategy: ConnOpenRoundRobin}
+	if err := o.Validate(); err == nil {
+		t.Fatalf("expected Validate to reject Balancer + non-default ConnOpenStrategy")
+	}
+
+	o = &Options{Balancer: NewRandomBalancer()}
+	if err := o.Validate(); err != nil {
+		t.Fatalf("Validate with only Balancer set: unexpected error: %v", err)
+	}
+}
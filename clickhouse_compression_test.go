@@ -0,0 +1,99 @@
+// Licensed to ClickHouse, Inc. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. ClickHouse, Inc. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package clickhouse
+
+import "testing"
+
+func TestParseCompression(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    Compression
+		wantErr bool
+	}{
+		{name: "lz4 no level", value: "lz4", want: Compression{Method: CompressionLZ4, Level: 0}},
+		{name: "lz4 with level", value: "lz4/5", want: Compression{Method: CompressionLZ4, Level: 5}},
+		{name: "lz4 level out of range", value: "lz4/13", wantErr: true},
+		{name: "zstd no level uses zstd default", value: "zstd", want: Compression{Method: CompressionZSTD, Level: 3}},
+		{name: "zstd with level", value: "zstd/22", want: Compression{Method: CompressionZSTD, Level: 22}},
+		{name: "zstd level 0 is out of range", value: "zstd/0", wantErr: true},
+		{name: "zstd level out of range", value: "zstd/23", wantErr: true},
+		{name: "gzip no level uses -1 default", value: "gzip", want: Compression{Method: CompressionGZIP, Level: -1}},
+		{name: "gzip with level", value: "gzip/9", want: Compression{Method: CompressionGZIP, Level: 9}},
+		{name: "gzip level out of range", value: "gzip/10", wantErr: true},
+		{name: "none", value: "none", want: Compression{Method: CompressionNone, Level: 0}},
+		{name: "unknown method", value: "snappy", wantErr: true},
+		{name: "non numeric level", value: "gzip/abc", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCompression(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseCompression(%q): expected error, got nil", tt.value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseCompression(%q): unexpected error: %v", tt.value, err)
+			}
+			if *got != tt.want {
+				t.Fatalf("parseCompression(%q) = %+v, want %+v", tt.value, *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromDSNCompress(t *testing.T) {
+	tests := []struct {
+		name    string
+		dsn     string
+		want    *Compression
+		wantErr bool
+	}{
+		{name: "compress=true defaults to lz4", dsn: "tcp://host:9000?compress=true", want: &Compression{Method: CompressionLZ4}},
+		{name: "compress=1 defaults to lz4", dsn: "tcp://host:9000?compress=1", want: &Compression{Method: CompressionLZ4}},
+		{name: "compress=false is no compression", dsn: "tcp://host:9000?compress=false"},
+		{name: "compress=zstd/9", dsn: "tcp://host:9000?compress=zstd/9", want: &Compression{Method: CompressionZSTD, Level: 9}},
+		{name: "compress=zstd/99 out of range", dsn: "tcp://host:9000?compress=zstd/99", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opt := &Options{}
+			err := opt.fromDSN(tt.dsn)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("fromDSN(%q): expected error, got nil", tt.dsn)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("fromDSN(%q): unexpected error: %v", tt.dsn, err)
+			}
+			if tt.want == nil {
+				if opt.Compression != nil {
+					t.Fatalf("fromDSN(%q): Compression = %+v, want nil", tt.dsn, opt.Compression)
+				}
+				return
+			}
+			if opt.Compression == nil || *opt.Compression != *tt.want {
+				t.Fatalf("fromDSN(%q): Compression = %+v, want %+v", tt.dsn, opt.Compression, tt.want)
+			}
+		})
+	}
+}
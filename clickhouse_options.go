@@ -20,12 +20,15 @@ package clickhouse
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"github.com/ClickHouse/ch-go/compress"
 	"net"
 	"net/url"
+	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -61,6 +64,7 @@ type Auth struct { // has_control_character
 
 type Compression struct {
 	Method CompressionMethod
+	Level  int
 }
 
 type ConnOpenStrategy uint8
@@ -112,9 +116,18 @@ type Options struct {
 	MaxIdleConns     int           // default 5
 	ConnMaxLifetime  time.Duration // default 1 hour
 	ConnOpenStrategy ConnOpenStrategy
+	Balancer         Balancer // mutually exclusive with ConnOpenStrategy
 
-	scheme      string
-	ReadTimeout time.Duration
+	scheme       string
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration // default 1 minute
+
+	balancerOnce     sync.Once
+	resolvedBalancer Balancer
+
+	addrMu    sync.Mutex
+	lastIndex int
+	hasPicked bool
 }
 
 func (o *Options) fromDSN(in string) error {
@@ -131,9 +144,14 @@ func (o *Options) fromDSN(in string) error {
 	}
 	o.Addr = append(o.Addr, strings.Split(dsn.Host, ",")...)
 	var (
-		secure     bool
-		params     = dsn.Query()
-		skipVerify bool
+		secure         bool
+		params         = dsn.Query()
+		skipVerify     bool
+		tlsCAFile      string
+		tlsCertFile    string
+		tlsKeyFile     string
+		tlsServerName  string
+		httpOnlyParams []string
 	)
 	o.Auth.Database = strings.TrimPrefix(dsn.Path, "/")
 	for v := range params {
@@ -141,11 +159,21 @@ func (o *Options) fromDSN(in string) error {
 		case "debug":
 			o.Debug, _ = strconv.ParseBool(params.Get(v))
 		case "compress":
-			if on, _ := strconv.ParseBool(params.Get(v)); on {
-				o.Compression = &Compression{
-					Method: CompressionLZ4,
+			value := params.Get(v)
+			if on, err := strconv.ParseBool(value); err == nil {
+				if on {
+					o.Compression = &Compression{
+						Method: CompressionLZ4,
+						Level:  compressionDefaultLevel(CompressionLZ4),
+					}
 				}
+				break
+			}
+			compression, err := parseCompression(value)
+			if err != nil {
+				return fmt.Errorf("clickhouse [dsn parse]: compress: %s", err)
 			}
+			o.Compression = compression
 		case "dial_timeout":
 			duration, err := time.ParseDuration(params.Get(v))
 			if err != nil {
@@ -158,17 +186,38 @@ func (o *Options) fromDSN(in string) error {
 				return fmt.Errorf("clickhouse [dsn parse]: http timeout: %s", err)
 			}
 			o.ReadTimeout = duration
+		case "write_timeout":
+			duration, err := time.ParseDuration(params.Get(v))
+			if err != nil {
+				return fmt.Errorf("clickhouse [dsn parse]: write timeout: %s", err)
+			}
+			o.WriteTimeout = duration
 		case "secure":
 			secure = true
 		case "skip_verify":
 			skipVerify = true
+		case "tls_ca_file":
+			tlsCAFile = params.Get(v)
+		case "tls_cert_file":
+			tlsCertFile = params.Get(v)
+		case "tls_key_file":
+			tlsKeyFile = params.Get(v)
+		case "tls_server_name":
+			tlsServerName = params.Get(v)
 		case "connection_open_strategy":
 			switch params.Get(v) {
 			case "in_order":
 				o.ConnOpenStrategy = ConnOpenInOrder
 			case "round_robin":
 				o.ConnOpenStrategy = ConnOpenRoundRobin
+			case "random":
+				o.Balancer = NewRandomBalancer()
+			case "health_aware":
+				o.Balancer = NewHealthAwareBalancer(HealthAwareBalancerConfig{})
 			}
+		case "session_id", "session_timeout", "session_check":
+			httpOnlyParams = append(httpOnlyParams, v)
+			o.Settings[v] = params.Get(v)
 
 		default:
 			switch p := strings.ToLower(params.Get(v)); p {
@@ -190,6 +239,35 @@ func (o *Options) fromDSN(in string) error {
 			InsecureSkipVerify: skipVerify,
 		}
 	}
+	if tlsCAFile != "" || tlsCertFile != "" || tlsKeyFile != "" || tlsServerName != "" {
+		if !secure {
+			return fmt.Errorf("clickhouse [dsn parse]: tls_ca_file/tls_cert_file/tls_key_file/tls_server_name require secure=true")
+		}
+		if tlsCAFile != "" {
+			caCert, err := os.ReadFile(tlsCAFile)
+			if err != nil {
+				return fmt.Errorf("clickhouse [dsn parse]: tls_ca_file: %s", err)
+			}
+			caCertPool := x509.NewCertPool()
+			if !caCertPool.AppendCertsFromPEM(caCert) {
+				return fmt.Errorf("clickhouse [dsn parse]: tls_ca_file: no valid certificates found in %s", tlsCAFile)
+			}
+			o.TLS.RootCAs = caCertPool
+		}
+		if tlsCertFile != "" || tlsKeyFile != "" {
+			if tlsCertFile == "" || tlsKeyFile == "" {
+				return fmt.Errorf("clickhouse [dsn parse]: tls_cert_file and tls_key_file must be specified together")
+			}
+			cert, err := tls.LoadX509KeyPair(tlsCertFile, tlsKeyFile)
+			if err != nil {
+				return fmt.Errorf("clickhouse [dsn parse]: tls_cert_file/tls_key_file: %s", err)
+			}
+			o.TLS.Certificates = []tls.Certificate{cert}
+		}
+		if tlsServerName != "" {
+			o.TLS.ServerName = tlsServerName
+		}
+	}
 	o.scheme = dsn.Scheme
 	switch dsn.Scheme {
 	case "http":
@@ -205,9 +283,118 @@ func (o *Options) fromDSN(in string) error {
 	default:
 		o.Protocol = Native
 	}
+	if o.Protocol != HTTP && len(httpOnlyParams) != 0 {
+		return fmt.Errorf("clickhouse [dsn parse]: %s: only valid over HTTP", strings.Join(httpOnlyParams, ", "))
+	}
+	if o.Protocol == HTTP && o.Compression != nil && o.Compression.Method == CompressionGZIP {
+		if _, ok := o.Settings["enable_http_compression"]; !ok {
+			o.Settings["enable_http_compression"] = 1
+		}
+	}
+	return o.Validate()
+}
+
+// Validate reports conflicting Options configuration. It is called
+// automatically when parsing a DSN; callers building Options directly (not
+// via ParseDSN) should call it before opening a connection. Note that since
+// ConnOpenInOrder is also ConnOpenStrategy's zero value, setting Balancer
+// alongside an explicit (but default-valued) ConnOpenStrategy is
+// indistinguishable from never having set ConnOpenStrategy at all.
+func (o *Options) Validate() error {
+	if o.Balancer != nil && o.ConnOpenStrategy != ConnOpenInOrder {
+		return fmt.Errorf("clickhouse: Balancer and ConnOpenStrategy are mutually exclusive")
+	}
 	return nil
 }
 
+// balancer returns the Balancer to use for picking which address to dial,
+// translating the legacy ConnOpenStrategy into the matching built-in the
+// first time it's needed when Options.Balancer isn't set. The result is
+// cached so that stateful balancers (RoundRobin, HealthAware) keep their
+// state across dials.
+func (o *Options) balancer() Balancer {
+	o.balancerOnce.Do(func() {
+		if o.Balancer != nil {
+			o.resolvedBalancer = o.Balancer
+			return
+		}
+		switch o.ConnOpenStrategy {
+		case ConnOpenRoundRobin:
+			o.resolvedBalancer = NewRoundRobinBalancer()
+		default:
+			o.resolvedBalancer = NewInOrderBalancer()
+		}
+	})
+	return o.resolvedBalancer
+}
+
+// parseCompression parses a compress DSN value of the form "<method>" or
+// "<method>/<level>", e.g. "gzip/1", "zstd/9", "lz4/0". This mirrors the
+// TypeWithLevel encoding used elsewhere in ClickHouse (gzip/0..gzip/9).
+func parseCompression(value string) (*Compression, error) {
+	parts := strings.SplitN(value, "/", 2)
+	compression := &Compression{}
+	switch method := strings.ToLower(parts[0]); method {
+	case "none":
+		compression.Method = CompressionNone
+	case "lz4":
+		compression.Method = CompressionLZ4
+	case "zstd":
+		compression.Method = CompressionZSTD
+	case "gzip":
+		compression.Method = CompressionGZIP
+	default:
+		return nil, fmt.Errorf("invalid compression method %q", parts[0])
+	}
+	if len(parts) == 1 {
+		compression.Level = compressionDefaultLevel(compression.Method)
+		return compression, nil
+	}
+	level, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid compression level %q", parts[1])
+	}
+	min, max, ok := compressionLevelRange(compression.Method)
+	if !ok {
+		return nil, fmt.Errorf("compression method %q does not support a level", parts[0])
+	}
+	if level < min || level > max {
+		return nil, fmt.Errorf("compression level %d out of range [%d, %d] for method %q", level, min, max, parts[0])
+	}
+	compression.Level = level
+	return compression, nil
+}
+
+// compressionDefaultLevel returns the level used when a compress DSN value
+// names a method but no explicit "/level", mirroring each codec's own notion
+// of "default" rather than leaving the ambiguous Go zero value in place (0 is
+// "no compression" for gzip/zlib and out of range for zstd).
+func compressionDefaultLevel(method CompressionMethod) int {
+	switch method {
+	case CompressionGZIP:
+		return -1 // zlib/gzip: -1 means "use the library default"
+	case CompressionZSTD:
+		return 3 // zstd's own default level
+	default:
+		return 0 // lz4's default acceleration, and none/unset
+	}
+}
+
+// compressionLevelRange returns the valid level range for method, and whether
+// method supports levels at all.
+func compressionLevelRange(method CompressionMethod) (min, max int, ok bool) {
+	switch method {
+	case CompressionGZIP:
+		return -1, 9, true
+	case CompressionZSTD:
+		return 1, 22, true
+	case CompressionLZ4:
+		return 0, 12, true
+	default:
+		return 0, 0, false
+	}
+}
+
 func (o *Options) setDefaults() {
 	if len(o.Auth.Database) == 0 {
 		o.Auth.Database = "default"
@@ -218,6 +405,9 @@ func (o *Options) setDefaults() {
 	if o.DialTimeout == 0 {
 		o.DialTimeout = time.Second
 	}
+	if o.WriteTimeout == 0 {
+		o.WriteTimeout = time.Minute
+	}
 	if o.MaxIdleConns <= 0 {
 		o.MaxIdleConns = 5
 	}
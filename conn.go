@@ -0,0 +1,123 @@
+// Licensed to ClickHouse, Inc. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. ClickHouse, Inc. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package clickhouse
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ReportQueryError reports the outcome of a query sent to addr to the
+// configured Balancer, so that network errors seen during normal traffic -
+// not just dial failures - steer future picks away from unhealthy nodes. The
+// pool should call this after every query with a network error (err may be
+// nil to mark addr healthy again, e.g. after a successful retry).
+func (o *Options) ReportQueryError(addr string, err error) {
+	o.balancer().Report(addr, err)
+}
+
+// open picks an address from o.Addr using the configured Balancer (falling
+// back to the built-in matching o.ConnOpenStrategy when no Balancer is set),
+// dials it, and reports the outcome back to the Balancer so it can steer
+// future picks away from addresses that are failing.
+func (o *Options) open(ctx context.Context) (net.Conn, string, error) {
+	balancer := o.balancer()
+
+	o.addrMu.Lock()
+	state := BalancerState{LastIndex: -1}
+	if o.hasPicked {
+		state.LastIndex = o.lastIndex
+	}
+	o.addrMu.Unlock()
+
+	addr, err := balancer.Pick(ctx, o.Addr, state)
+	if err != nil {
+		return nil, "", err
+	}
+
+	o.addrMu.Lock()
+	for i, a := range o.Addr {
+		if a == addr {
+			o.lastIndex = i
+			o.hasPicked = true
+			break
+		}
+	}
+	o.addrMu.Unlock()
+
+	conn, err := o.dial(ctx, addr)
+	balancer.Report(addr, err)
+	if err != nil {
+		return nil, addr, err
+	}
+	return conn, addr, nil
+}
+
+// dial opens a connection to addr using o.DialContext if set, otherwise a
+// net.Dialer configured with o.DialTimeout, and wraps the result so that
+// every Write (one native-protocol write syscall, or one HTTP request body
+// write) is bounded by o.WriteTimeout via SetWriteDeadline.
+func (o *Options) dial(ctx context.Context, addr string) (net.Conn, error) {
+	dial := o.DialContext
+	if dial == nil {
+		dialer := &net.Dialer{Timeout: o.DialTimeout}
+		dial = func(ctx context.Context, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "tcp", addr)
+		}
+	}
+	conn, err := dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	return newWriteTimeoutConn(conn, o.WriteTimeout), nil
+}
+
+// httpTransport returns an *http.Transport whose DialContext wraps
+// connections the same way o.dial does, so HTTP request body writes share
+// the WriteTimeout enforcement used by the native protocol.
+func (o *Options) httpTransport() *http.Transport {
+	return &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return o.dial(ctx, addr)
+		},
+	}
+}
+
+// writeTimeoutConn wraps a net.Conn so that each Write is preceded by a
+// SetWriteDeadline derived from timeout, bounding slow writes (e.g. a large
+// INSERT batch) without affecting reads.
+type writeTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func newWriteTimeoutConn(conn net.Conn, timeout time.Duration) net.Conn {
+	if timeout <= 0 {
+		return conn
+	}
+	return &writeTimeoutConn{Conn: conn, timeout: timeout}
+}
+
+func (c *writeTimeoutConn) Write(p []byte) (int, error) {
+	if err := c.Conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(p)
+}
@@ -0,0 +1,119 @@
+// Licensed to ClickHouse, Inc. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. ClickHouse, Inc. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package clickhouse
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestWriteTimeoutConnTimesOut(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	conn := newWriteTimeoutConn(client, 50*time.Millisecond)
+	start := time.Now()
+	// net.Pipe is unbuffered and nothing reads from server, so this Write
+	// blocks until the deadline set by writeTimeoutConn fires.
+	_, err := conn.Write([]byte("hello"))
+	elapsed := time.Since(start)
+
+	if err == nil {
+		t.Fatalf("expected the write to time out, got nil error")
+	}
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Fatalf("expected a net.Error timeout, got %v (%T)", err, err)
+	}
+	if elapsed > 5*time.Second {
+		t.Fatalf("write took %v to time out, want close to 50ms", elapsed)
+	}
+}
+
+func TestNewWriteTimeoutConnZeroDisables(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	conn := newWriteTimeoutConn(client, 0)
+	if _, ok := conn.(*writeTimeoutConn); ok {
+		t.Fatalf("timeout <= 0 should return the conn unwrapped")
+	}
+}
+
+func TestOptionsDialWrapsWriteTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(io.Discard, conn)
+	}()
+
+	o := &Options{WriteTimeout: time.Second}
+	conn, err := o.dial(context.Background(), ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	if _, ok := conn.(*writeTimeoutConn); !ok {
+		t.Fatalf("dial returned %T, want it wrapped in *writeTimeoutConn", conn)
+	}
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+}
+
+// TestHTTPTransportDialsThroughOptionsDial exercises httpTransport's
+// DialContext end-to-end: it must compile against http.Transport's
+// func(ctx, network, addr string) (net.Conn, error) shape and actually reach
+// a listener via o.dial.
+func TestHTTPTransportDialsThroughOptionsDial(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(io.Discard, conn)
+	}()
+
+	o := &Options{WriteTimeout: time.Second}
+	transport := o.httpTransport()
+	conn, err := transport.DialContext(context.Background(), "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("DialContext: %v", err)
+	}
+	defer conn.Close()
+	if _, ok := conn.(*writeTimeoutConn); !ok {
+		t.Fatalf("httpTransport's DialContext returned %T, want it wrapped in *writeTimeoutConn", conn)
+	}
+}
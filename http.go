@@ -0,0 +1,147 @@
+// Licensed to ClickHouse, Inc. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. ClickHouse, Inc. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package clickhouse
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// httpClient returns an *http.Client configured for the HTTP protocol: its
+// Transport is o.httpRoundTripper(), so every request made through it gets
+// gzip request/response handling for free whenever Compression is gzip.
+func (o *Options) httpClient() *http.Client {
+	return &http.Client{Transport: o.httpRoundTripper()}
+}
+
+// httpRequest builds the *http.Request ClickHouse's HTTP interface expects to
+// run query against endpoint (e.g. "http://host:8123/"), merging o.httpQuery()
+// - and therefore any session_id/session_timeout/session_check/
+// enable_http_compression settings - into the request's query string
+// alongside ClickHouse's own "query" parameter.
+func (o *Options) httpRequest(ctx context.Context, endpoint, query string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	values := o.httpQuery()
+	values.Set("query", query)
+	req.URL.RawQuery = values.Encode()
+	return req, nil
+}
+
+// httpRoundTripper returns the http.RoundTripper to use for the HTTP
+// protocol: o.httpTransport() as-is, or wrapped in gzipRoundTripper when
+// Compression is configured for CompressionGZIP, so that INSERT bodies are
+// sent with Content-Encoding: gzip and responses are requested with
+// Accept-Encoding: gzip.
+func (o *Options) httpRoundTripper() http.RoundTripper {
+	next := o.httpTransport()
+	if o.Compression == nil || o.Compression.Method != CompressionGZIP {
+		return next
+	}
+	return &gzipRoundTripper{next: next, level: o.Compression.Level}
+}
+
+// httpQuery returns the ClickHouse HTTP query-string parameters derived from
+// o.Settings - including session_id/session_timeout/session_check, which
+// fromDSN stores there - so that HTTP requests carry the same session /
+// temporary-table context the native protocol gets for free from the
+// connection itself.
+func (o *Options) httpQuery() url.Values {
+	values := make(url.Values, len(o.Settings))
+	for k, v := range o.Settings {
+		values.Set(k, fmt.Sprintf("%v", v))
+	}
+	return values
+}
+
+// gzipRoundTripper gzip-encodes request bodies and negotiates gzip response
+// bodies, mirroring the compression the native protocol gets from
+// ch-go/compress.
+type gzipRoundTripper struct {
+	next  http.RoundTripper
+	level int
+}
+
+func (t *gzipRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		level := t.level
+		if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+			level = gzip.DefaultCompression
+		}
+		var buf bytes.Buffer
+		gz, err := gzip.NewWriterLevel(&buf, level)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := gz.Write(body); err != nil {
+			return nil, err
+		}
+		if err := gz.Close(); err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(&buf)
+		req.ContentLength = int64(buf.Len())
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Body != nil && resp.Header.Get("Content-Encoding") == "gzip" {
+		gzr, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		resp.Body = &gzipReadCloser{gzr: gzr, body: resp.Body}
+	}
+	return resp, nil
+}
+
+// gzipReadCloser decompresses a gzip response body and closes both the
+// gzip.Reader and the underlying response body together.
+type gzipReadCloser struct {
+	gzr  *gzip.Reader
+	body io.ReadCloser
+}
+
+func (g *gzipReadCloser) Read(p []byte) (int, error) {
+	return g.gzr.Read(p)
+}
+
+func (g *gzipReadCloser) Close() error {
+	gzErr := g.gzr.Close()
+	bodyErr := g.body.Close()
+	if gzErr != nil {
+		return gzErr
+	}
+	return bodyErr
+}
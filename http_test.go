@@ -0,0 +1,146 @@
+// Licensed to ClickHouse, Inc. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. ClickHouse, Inc. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package clickhouse
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTPRoundTripperGzipsRequestAndResponse(t *testing.T) {
+	const reqBody = "INSERT INTO t VALUES (1), (2), (3)"
+	const respBody = `{"ok":true}`
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Content-Encoding"); got != "gzip" {
+			t.Errorf("request Content-Encoding = %q, want %q", got, "gzip")
+		}
+		if got := r.Header.Get("Accept-Encoding"); got != "gzip" {
+			t.Errorf("request Accept-Encoding = %q, want %q", got, "gzip")
+		}
+		gzr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Errorf("server: gzip.NewReader: %v", err)
+			return
+		}
+		defer gzr.Close()
+		body, err := io.ReadAll(gzr)
+		if err != nil {
+			t.Errorf("server: read gzip body: %v", err)
+			return
+		}
+		if string(body) != reqBody {
+			t.Errorf("server saw body %q, want %q", body, reqBody)
+		}
+
+		w.Header().Set("Content-Encoding", "gzip")
+		gzw := gzip.NewWriter(w)
+		gzw.Write([]byte(respBody))
+		gzw.Close()
+	}))
+	defer srv.Close()
+
+	o := &Options{Compression: &Compression{Method: CompressionGZIP}}
+	client := o.httpClient()
+
+	req, err := o.httpRequest(context.Background(), srv.URL, "INSERT INTO t VALUES", strings.NewReader(reqBody))
+	if err != nil {
+		t.Fatalf("httpRequest: %v", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("client.Do: %v", err)
+	}
+	defer resp.Body.Close()
+
+	got, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	if string(got) != respBody {
+		t.Fatalf("response body = %q, want %q", got, respBody)
+	}
+}
+
+func TestHTTPRequestForwardsSessionParams(t *testing.T) {
+	var gotQuery string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotQuery = r.URL.RawQuery
+	}))
+	defer srv.Close()
+
+	o := &Options{Settings: Settings{
+		"session_id":      "abc123",
+		"session_timeout": 60,
+		"session_check":   1,
+	}}
+	req, err := o.httpRequest(context.Background(), srv.URL, "SELECT 1", nil)
+	if err != nil {
+		t.Fatalf("httpRequest: %v", err)
+	}
+	if _, err := o.httpClient().Do(req); err != nil {
+		t.Fatalf("client.Do: %v", err)
+	}
+
+	values := req.URL.Query()
+	if got := values.Get("query"); got != "SELECT 1" {
+		t.Fatalf("query param = %q, want %q", got, "SELECT 1")
+	}
+	for k, want := range map[string]string{
+		"session_id":      "abc123",
+		"session_timeout": "60",
+		"session_check":   "1",
+	} {
+		if got := values.Get(k); got != want {
+			t.Fatalf("param %q = %q, want %q (raw query %q)", k, got, want, gotQuery)
+		}
+	}
+}
+
+func TestHTTPRoundTripperPassesThroughWithoutCompression(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Content-Encoding"); got != "" {
+			t.Errorf("request Content-Encoding = %q, want none", got)
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	o := &Options{}
+	req, err := o.httpRequest(context.Background(), srv.URL, "SELECT 1", strings.NewReader("SELECT 1"))
+	if err != nil {
+		t.Fatalf("httpRequest: %v", err)
+	}
+	resp, err := o.httpClient().Do(req)
+	if err != nil {
+		t.Fatalf("client.Do: %v", err)
+	}
+	defer resp.Body.Close()
+	got, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	if string(got) != "ok" {
+		t.Fatalf("response body = %q, want %q", got, "ok")
+	}
+}